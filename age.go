@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+const (
+	ageArmorHeader  = "-----BEGIN AGE ENCRYPTED FILE-----"
+	ageBinaryHeader = "age-encryption.org/v1"
+)
+
+// isAgeFile reports whether data looks like an age-encrypted file, either
+// ASCII-armored or in age's native binary format.
+func isAgeFile(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(ageArmorHeader)) || bytes.HasPrefix(data, []byte(ageBinaryHeader))
+}
+
+// decryptAge decrypts an age-encrypted file using the given identity files,
+// falling back to passphrase decryption (the same path `age` itself uses)
+// when none are supplied.
+func (app *App) decryptAge(data []byte, filename string, identityFiles []string) ([]byte, error) {
+	identities, err := app.ageIdentities(identityFiles, filename)
+	if err != nil {
+		return nil, fmt.Errorf("loading age identities: %w", err)
+	}
+
+	var src io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(data, []byte(ageArmorHeader)) {
+		src = armor.NewReader(src)
+	}
+
+	r, err := age.Decrypt(src, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting age file: %w", err)
+	}
+
+	return io.ReadAll(r)
+}
+
+// ageIdentities loads X25519 identities from identityFiles, or, if none are
+// given, prompts for a passphrase and returns a single scrypt identity.
+func (app *App) ageIdentities(identityFiles []string, filename string) ([]age.Identity, error) {
+	if len(identityFiles) == 0 {
+		password, err := app.readPassword("Password for %s: ", filename)
+		if err != nil {
+			return nil, fmt.Errorf("reading password: %w", err)
+		}
+
+		identity, err := age.NewScryptIdentity(string(password))
+		if err != nil {
+			return nil, fmt.Errorf("initializing scrypt identity: %w", err)
+		}
+		return []age.Identity{identity}, nil
+	}
+
+	var identities []age.Identity
+	for _, path := range identityFiles {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("opening identity file %s: %w", path, err)
+		}
+		fileIdentities, err := age.ParseIdentities(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parsing identity file %s: %w", path, err)
+		}
+		identities = append(identities, fileIdentities...)
+	}
+
+	return identities, nil
+}
+
+// encryptStdinAge encrypts stdin with age, to either the given recipients
+// or, if none are given, a passphrase prompted interactively.
+func (app *App) encryptStdinAge(f io.Writer, recipientStrs []string) ([]string, error) {
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("read environment from stdin: %w", err)
+	}
+
+	env, err := app.parseEnv(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing environment: %w", err)
+	}
+
+	recipients, err := app.ageRecipients(recipientStrs)
+	if err != nil {
+		return nil, fmt.Errorf("resolving age recipients: %w", err)
+	}
+
+	w, err := age.Encrypt(f, recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("initializing age encryption: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("writing encrypted data: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("finalizing encrypted data: %w", err)
+	}
+
+	return env, nil
+}
+
+// ageRecipients parses recipientStrs as age X25519 public keys, or, if none
+// are given, prompts for a passphrase and returns a single scrypt recipient.
+func (app *App) ageRecipients(recipientStrs []string) ([]age.Recipient, error) {
+	if len(recipientStrs) == 0 {
+		password, err := app.readPassword("Password: ")
+		if err != nil {
+			return nil, fmt.Errorf("reading password: %w", err)
+		}
+
+		recipient, err := age.NewScryptRecipient(string(password))
+		if err != nil {
+			return nil, fmt.Errorf("initializing scrypt recipient: %w", err)
+		}
+		return []age.Recipient{recipient}, nil
+	}
+
+	var recipients []age.Recipient
+	for _, s := range recipientStrs {
+		if !strings.HasPrefix(s, "age1") {
+			return nil, fmt.Errorf("invalid age recipient: %s", s)
+		}
+		recipient, err := age.ParseX25519Recipient(s)
+		if err != nil {
+			return nil, fmt.Errorf("parsing recipient %s: %w", s, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	return recipients, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+func TestDecryptAgeArmoredRoundTrip(t *testing.T) {
+	t.Setenv(passwordEnvName, "hunter2")
+
+	recipient, err := age.NewScryptRecipient("hunter2")
+	if err != nil {
+		t.Fatalf("NewScryptRecipient: %v", err)
+	}
+
+	var buf bytes.Buffer
+	armorWriter := armor.NewWriter(&buf)
+	w, err := age.Encrypt(armorWriter, recipient)
+	if err != nil {
+		t.Fatalf("age.Encrypt: %v", err)
+	}
+	if _, err := w.Write([]byte("FOO=bar\n")); err != nil {
+		t.Fatalf("writing plaintext: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("closing age writer: %v", err)
+	}
+	if err := armorWriter.Close(); err != nil {
+		t.Fatalf("closing armor writer: %v", err)
+	}
+
+	if !isAgeFile(buf.Bytes()) {
+		t.Fatalf("isAgeFile returned false for armored age data")
+	}
+
+	var app App
+	got, err := app.decryptAge(buf.Bytes(), "test.age", nil)
+	if err != nil {
+		t.Fatalf("decryptAge: %v", err)
+	}
+	if string(got) != "FOO=bar\n" {
+		t.Fatalf("decrypted data = %q, want %q", got, "FOO=bar\n")
+	}
+}
+
+func TestDecryptAgeBinaryRoundTrip(t *testing.T) {
+	t.Setenv(passwordEnvName, "hunter2")
+
+	recipient, err := age.NewScryptRecipient("hunter2")
+	if err != nil {
+		t.Fatalf("NewScryptRecipient: %v", err)
+	}
+
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, recipient)
+	if err != nil {
+		t.Fatalf("age.Encrypt: %v", err)
+	}
+	if _, err := w.Write([]byte("FOO=bar\n")); err != nil {
+		t.Fatalf("writing plaintext: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("closing age writer: %v", err)
+	}
+
+	var app App
+	got, err := app.decryptAge(buf.Bytes(), "test.age", nil)
+	if err != nil {
+		t.Fatalf("decryptAge: %v", err)
+	}
+	if string(got) != "FOO=bar\n" {
+		t.Fatalf("decrypted data = %q, want %q", got, "FOO=bar\n")
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// runEdit implements the `envs edit <file>` subcommand: it decrypts file
+// into a temp file, lets the user edit it with their editor, validates the
+// result, then re-encrypts it back in place.
+func runEdit(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: envs edit <file>")
+	}
+	filename := args[0]
+
+	var app App
+	raw, err := app.readSource(filename)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", filename, err)
+	}
+	reedSolomon := isReedSolomonFile(raw)
+
+	resolved := raw
+	if reedSolomon {
+		resolved, err = app.readReedSolomon(resolved, false)
+		if err != nil {
+			return fmt.Errorf("reading erasure-coded file %s: %w", filename, err)
+		}
+	}
+
+	data, password, err := app.decryptData(resolved, filename, nil)
+	if err != nil {
+		return fmt.Errorf("decrypting %s: %w", filename, err)
+	}
+	if password == nil {
+		return errors.New("edit only supports password-encrypted files")
+	}
+
+	tmpPath, err := app.writeEditTemp(data)
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	defer app.wipeAndRemove(tmpPath)
+
+	if err := app.runEditor(tmpPath); err != nil {
+		return fmt.Errorf("running editor: %w", err)
+	}
+
+	edited, err := os.ReadFile(tmpPath)
+	if err != nil {
+		return fmt.Errorf("reading edited file: %w", err)
+	}
+
+	if _, err := app.parseEnv(edited); err != nil {
+		return fmt.Errorf("validating edited environment: %w", err)
+	}
+
+	return app.replaceEncrypted(filename, edited, password, reedSolomon)
+}
+
+// writeEditTemp writes data to a new, securely-created temp file under
+// $XDG_RUNTIME_DIR (falling back to os.TempDir) so the decrypted contents
+// never touch a world-readable location.
+func (app *App) writeEditTemp(data []byte) (string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	f, err := os.CreateTemp(dir, "envs-edit-*")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := f.Chmod(0o600); err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+// runEditor launches $EDITOR (or $VISUAL, falling back to vi) on path and
+// waits for it to exit.
+func (app *App) runEditor(path string) error {
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = os.Getenv("VISUAL")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	cmd := exec.Command(editor, path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// wipeAndRemove overwrites path with zeroes before unlinking it, so the
+// decrypted plaintext doesn't linger on disk after a crash or early return.
+func (app *App) wipeAndRemove(path string) {
+	if info, err := os.Stat(path); err == nil {
+		if f, err := os.OpenFile(path, os.O_WRONLY, 0o600); err == nil {
+			zeroes := make([]byte, info.Size())
+			f.Write(zeroes)
+			f.Close()
+		}
+	}
+	os.Remove(path)
+}
+
+// replaceEncrypted re-encrypts data with password and atomically replaces
+// filename with the result, only after the new file has been fully written
+// and closed successfully. If reedSolomon is set (the original file was
+// wrapped with -e -r), the erasure coding is re-applied so editing a
+// paranoid-mode file doesn't silently drop that protection.
+func (app *App) replaceEncrypted(filename string, data, password []byte, reedSolomon bool) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating replacement file: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	if reedSolomon {
+		var buf bytes.Buffer
+		if err := app.encryptData(&buf, data, password); err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return fmt.Errorf("re-encrypting: %w", err)
+		}
+		err = app.writeReedSolomon(tmp, buf.Bytes(), rsDataShards, rsParityShards)
+	} else {
+		err = app.encryptData(tmp, data, password)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("re-encrypting: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("closing replacement file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, filename); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("replacing %s: %w", filename, err)
+	}
+
+	return nil
+}
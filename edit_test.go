@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeStubEditor writes an executable shell script that overwrites its
+// argument file with newContent, and returns its path, so tests can drive
+// runEdit without a real terminal editor.
+func writeStubEditor(t *testing.T, newContent string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "stub-editor.sh")
+	script := "#!/bin/sh\nprintf '%s' \"$CONTENT\" > \"$1\"\n"
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatalf("writing stub editor: %v", err)
+	}
+	t.Setenv("CONTENT", newContent)
+	return path
+}
+
+func TestRunEditRoundTrip(t *testing.T) {
+	t.Setenv(passwordEnvName, "hunter2")
+	t.Setenv("EDITOR", writeStubEditor(t, "FOO=bar\nBAZ=qux\n"))
+
+	var app App
+	path := filepath.Join(t.TempDir(), "env.enc")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("creating file: %v", err)
+	}
+	if err := app.encryptData(f, []byte("FOO=old\n"), []byte("hunter2")); err != nil {
+		t.Fatalf("encryptData: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("closing file: %v", err)
+	}
+
+	if err := runEdit([]string{path}); err != nil {
+		t.Fatalf("runEdit: %v", err)
+	}
+
+	env, err := app.readFile(path, nil, false)
+	if err != nil {
+		t.Fatalf("reading edited file: %v", err)
+	}
+
+	want := []string{"FOO=bar", "BAZ=qux"}
+	if len(env) != len(want) {
+		t.Fatalf("env = %v, want %v", env, want)
+	}
+	for i, e := range want {
+		if env[i] != e {
+			t.Fatalf("env[%d] = %q, want %q", i, env[i], e)
+		}
+	}
+}
+
+// TestRunEditPreservesReedSolomon exercises the chunk0-5 fix: editing a
+// file originally written with -e -r must still be Reed-Solomon wrapped
+// afterwards, not silently downgraded to plain AEAD.
+func TestRunEditPreservesReedSolomon(t *testing.T) {
+	t.Setenv(passwordEnvName, "hunter2")
+	t.Setenv("EDITOR", writeStubEditor(t, "FOO=bar\n"))
+
+	var app App
+	path := filepath.Join(t.TempDir(), "env.rs.enc")
+
+	var buf bytes.Buffer
+	if err := app.encryptData(&buf, []byte("FOO=old\n"), []byte("hunter2")); err != nil {
+		t.Fatalf("encryptData: %v", err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("creating file: %v", err)
+	}
+	if err := app.writeReedSolomon(f, buf.Bytes(), rsDataShards, rsParityShards); err != nil {
+		t.Fatalf("writeReedSolomon: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("closing file: %v", err)
+	}
+
+	if err := runEdit([]string{path}); err != nil {
+		t.Fatalf("runEdit: %v", err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading edited file: %v", err)
+	}
+	if !isReedSolomonFile(raw) {
+		t.Fatalf("edited file lost its Reed-Solomon wrapping")
+	}
+
+	env, err := app.readFile(path, nil, false)
+	if err != nil {
+		t.Fatalf("reading edited file: %v", err)
+	}
+	if len(env) != 1 || env[0] != "FOO=bar" {
+		t.Fatalf("env = %v, want [FOO=bar]", env)
+	}
+}
+
+// TestRunEditWipesTempFile checks that the decrypted scratch file created
+// under XDG_RUNTIME_DIR during an edit doesn't survive the call.
+func TestRunEditWipesTempFile(t *testing.T) {
+	t.Setenv(passwordEnvName, "hunter2")
+	t.Setenv("EDITOR", writeStubEditor(t, "FOO=bar\n"))
+
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+
+	var app App
+	path := filepath.Join(t.TempDir(), "env.enc")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("creating file: %v", err)
+	}
+	if err := app.encryptData(f, []byte("FOO=old\n"), []byte("hunter2")); err != nil {
+		t.Fatalf("encryptData: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("closing file: %v", err)
+	}
+
+	if err := runEdit([]string{path}); err != nil {
+		t.Fatalf("runEdit: %v", err)
+	}
+
+	leftovers, err := filepath.Glob(filepath.Join(runtimeDir, "envs-edit-*"))
+	if err != nil {
+		t.Fatalf("globbing runtime dir: %v", err)
+	}
+	if len(leftovers) != 0 {
+		t.Fatalf("decrypted scratch file(s) left behind: %v", leftovers)
+	}
+}
+
+// TestReplaceEncryptedAtomicOnFailure checks that a failing re-encryption
+// doesn't touch the original file.
+func TestReplaceEncryptedAtomicOnFailure(t *testing.T) {
+	var app App
+	path := filepath.Join(t.TempDir(), "env.enc")
+	original := []byte("original contents")
+	if err := os.WriteFile(path, original, 0o600); err != nil {
+		t.Fatalf("writing original file: %v", err)
+	}
+
+	// An empty password still encrypts fine, so force a failure a different
+	// way: point replaceEncrypted at a directory that can't hold the temp
+	// file's sibling, by using a filename whose directory doesn't exist.
+	missingDir := filepath.Join(t.TempDir(), "does-not-exist", "env.enc")
+	if err := app.replaceEncrypted(missingDir, []byte("FOO=bar\n"), []byte("hunter2"), false); err == nil {
+		t.Fatalf("replaceEncrypted succeeded with a nonexistent directory")
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading original file: %v", err)
+	}
+	if !bytes.Equal(got, original) {
+		t.Fatalf("original file was modified despite replaceEncrypted failing")
+	}
+}
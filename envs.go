@@ -3,10 +3,12 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"flag"
 	"fmt"
@@ -15,29 +17,92 @@ import (
 	"os/exec"
 	"strings"
 
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
 	"golang.org/x/term"
 )
 
 const (
-	// The magic number used to identify encrypted files
+	// The magic number used to identify legacy (unsalted SHA-256) encrypted files
 	magic = "\x00env"
+	// The magic number used to identify salted, KDF-versioned encrypted files
+	// that seal their payload in a single AEAD operation
+	magicV2 = "\x00env2"
+	// The magic number used to identify salted, KDF-versioned encrypted files
+	// that stream their payload as fixed-size AEAD chunks. Distinct from
+	// magicV2 so decrypt never has to guess whether a mode byte follows the
+	// magic number.
+	magicV3 = "\x00env3"
 	// The environment variable containing the password for encrypting and decrypting
 	passwordEnvName = "ENVS_PASSWORD"
+
+	// Supported header versions written by this binary
+	headerVersion = 1
+
+	// KDF identifiers stored in the file header
+	kdfScrypt   = byte(1)
+	kdfArgon2id = byte(2)
+
+	saltSize = 16
+	keyLen   = 32
+
+	scryptN = 32768
+	scryptR = 8
+	scryptP = 1
+
+	argon2Time    = 1
+	argon2Memory  = 64 * 1024 // KiB
+	argon2Threads = 4
+
+	// Default plaintext chunk size used by the streaming encryption mode
+	streamChunkSize = 64 * 1024
 )
 
+// kdfHeader describes the key-derivation function used to turn a password
+// into an AES-256 key, along with the parameters needed to reproduce it.
+type kdfHeader struct {
+	id   byte
+	salt []byte
+
+	// scrypt parameters
+	n, r, p uint32
+
+	// argon2id parameters
+	time, memory uint32
+	threads      uint8
+}
+
 type Options struct {
-	Files      FileList
-	IncludeEnv bool
-	Encrypt    bool
-	Print      bool
+	Files       FileList
+	IncludeEnv  bool
+	Encrypt     bool
+	Print       bool
+	Age         bool
+	Recipients  FileList
+	Identities  FileList
+	ReedSolomon bool
+	Fix         bool
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "edit" {
+		if err := runEdit(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var options Options
 	flag.BoolVar(&options.Encrypt, "e", false, "create encrypted environment from stdin")
-	flag.Var(&options.Files, "f", "configuration `file`")
+	flag.Var(&options.Files, "f", "configuration `file`, or a keyring://, op://, vault:// or exec:// secret source")
 	flag.BoolVar(&options.IncludeEnv, "i", false, "include surrounding environment")
 	flag.BoolVar(&options.Print, "p", false, "print environment variable in a format suitable for eval")
+	flag.BoolVar(&options.Age, "age", false, "use the age encryption format with -e, or allow reading age files")
+	flag.Var(&options.Recipients, "recipient", "age `recipient` public key to encrypt to with -age (repeatable)")
+	flag.Var(&options.Identities, "identity", "age `identity` file to decrypt with (repeatable)")
+	flag.BoolVar(&options.ReedSolomon, "r", false, "wrap the encrypted file in a Reed-Solomon erasure code with -e, to survive partial corruption")
+	flag.BoolVar(&options.Fix, "fix", false, "reconstruct corrupted shards of a Reed-Solomon protected file before decrypting")
 	flag.Parse()
 
 	var app App
@@ -68,14 +133,36 @@ func (app *App) Run(options Options, args []string) error {
 			defer f.Close()
 		}
 
-		password, err := app.readPassword("Password: ")
-		if err != nil {
-			return fmt.Errorf("reading password: %w", err)
+		// If Reed-Solomon protection was requested, encrypt into a buffer
+		// first so it can be erasure-coded as a whole before being written.
+		var out io.Writer = f
+		var buf bytes.Buffer
+		if options.ReedSolomon {
+			out = &buf
 		}
 
-		env, err = app.encryptStdin(f, password)
-		if err != nil {
-			return fmt.Errorf("encrypting environment: %w", err)
+		if options.Age {
+			var err error
+			env, err = app.encryptStdinAge(out, options.Recipients)
+			if err != nil {
+				return fmt.Errorf("encrypting environment: %w", err)
+			}
+		} else {
+			password, err := app.readPassword("Password: ")
+			if err != nil {
+				return fmt.Errorf("reading password: %w", err)
+			}
+
+			env, err = app.encryptStdin(out, password)
+			if err != nil {
+				return fmt.Errorf("encrypting environment: %w", err)
+			}
+		}
+
+		if options.ReedSolomon {
+			if err := app.writeReedSolomon(f, buf.Bytes(), rsDataShards, rsParityShards); err != nil {
+				return fmt.Errorf("erasure-coding environment: %w", err)
+			}
 		}
 	}
 
@@ -87,7 +174,7 @@ func (app *App) Run(options Options, args []string) error {
 	// Load environment from the requested files (if not already loaded)
 	if len(options.Files) > 0 && len(env) == 0 {
 		for _, filename := range options.Files {
-			fileEnv, err := app.readFile(filename)
+			fileEnv, err := app.readFile(filename, options.Identities, options.Fix)
 			if err != nil {
 				return fmt.Errorf("reading file %s: %w", filename, err)
 			}
@@ -128,69 +215,226 @@ func (app *App) readPassword(prompt string, args ...any) ([]byte, error) {
 	return password, err
 }
 
+// encryptStdin encrypts stdin to f without ever holding its whole plaintext
+// in memory at once: stdin is teed into the chunk-sealing writer and a
+// parser that extracts variable names from the very same bytes as they go
+// by. Because the two run concurrently, an invalid line deep in a large
+// file is only caught after everything before it has already been sealed
+// and written to f; that's the tradeoff for not having to buffer the file
+// twice.
 func (app *App) encryptStdin(f io.Writer, password []byte) ([]string, error) {
-	// Read all the data that should be encrypted
-	data, err := io.ReadAll(os.Stdin)
-	if err != nil {
-		return nil, fmt.Errorf("read environment from stdin: %w", err)
+	pr, pw := io.Pipe()
+	tee := io.TeeReader(os.Stdin, pw)
+
+	type parseResult struct {
+		env []string
+		err error
+	}
+	resultCh := make(chan parseResult, 1)
+	go func() {
+		env, err := app.parseEnvReader(pr)
+		resultCh <- parseResult{env, err}
+	}()
+
+	if err := app.encryptStream(f, tee, password); err != nil {
+		pw.CloseWithError(err)
+		<-resultCh
+		return nil, fmt.Errorf("encrypting environment: %w", err)
 	}
+	pw.Close()
 
-	// Parse the environment
-	env, err := app.parseEnv(data)
+	result := <-resultCh
+	if result.err != nil {
+		return nil, fmt.Errorf("parsing environment: %w", result.err)
+	}
+
+	return result.env, nil
+}
+
+// encryptData derives a fresh-salted key from password and writes data to f
+// as a streaming AEAD-encrypted file in the current on-disk format.
+func (app *App) encryptData(f io.Writer, data, password []byte) error {
+	return app.encryptStream(f, bytes.NewReader(data), password)
+}
+
+// encryptStream is like encryptData, but reads the plaintext from r instead
+// of requiring it to already be loaded into memory, so a large secrets file
+// never needs to be held in full before encryption can start.
+func (app *App) encryptStream(f io.Writer, r io.Reader, password []byte) error {
+	// Generate a fresh salt and derive the key with the default KDF
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return fmt.Errorf("generating salt: %w", err)
+	}
+	header := kdfHeader{id: kdfScrypt, salt: salt, n: scryptN, r: scryptR, p: scryptP}
+
+	key, err := app.deriveKey(password, header)
 	if err != nil {
-		return nil, fmt.Errorf("parsing environment: %w", err)
+		return fmt.Errorf("deriving key: %w", err)
 	}
 
 	// Initialize the encryption algorithm
-	gcm, err := app.createGCM(password)
+	gcm, err := app.newGCM(key)
 	if err != nil {
-		return nil, fmt.Errorf("initializing encryption algorithm: %w", err)
+		return fmt.Errorf("initializing encryption algorithm: %w", err)
 	}
 
-	// Create a random nonce
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, fmt.Errorf("generating nonce: %w", err)
+	// Create a random base nonce; per-chunk nonces are derived from it
+	baseNonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, baseNonce); err != nil {
+		return fmt.Errorf("generating nonce: %w", err)
 	}
 
-	// Encrypt the data in-place
-	data = gcm.Seal(data[:0], nonce, data, nil)
-
 	// Write the encrypted data
-	if _, err := f.Write([]byte(magic)); err != nil {
-		return nil, fmt.Errorf("writing magic number: %w", err)
+	if _, err := f.Write([]byte(magicV3)); err != nil {
+		return fmt.Errorf("writing magic number: %w", err)
 	}
-	if _, err := f.Write(nonce); err != nil {
-		return nil, fmt.Errorf("writing nonce: %w", err)
+	if err := app.writeKDFHeader(f, header); err != nil {
+		return fmt.Errorf("writing kdf header: %w", err)
 	}
-	if _, err := f.Write(data); err != nil {
-		return nil, fmt.Errorf("writing encrypted data: %w", err)
+	var chunkSizeBuf [4]byte
+	binary.BigEndian.PutUint32(chunkSizeBuf[:], streamChunkSize)
+	if _, err := f.Write(chunkSizeBuf[:]); err != nil {
+		return fmt.Errorf("writing chunk size: %w", err)
+	}
+	if _, err := f.Write(baseNonce); err != nil {
+		return fmt.Errorf("writing base nonce: %w", err)
+	}
+	if err := app.encryptChunks(f, r, gcm, baseNonce); err != nil {
+		return fmt.Errorf("encrypting chunks: %w", err)
 	}
 
-	return env, nil
+	return nil
 }
 
-func (app *App) readFile(filename string) ([]string, error) {
-	// Open file containing the environment
+// encryptChunks reads r in streamChunkSize plaintext chunks and seals each
+// one with its own nonce, derived from baseNonce and the chunk index, so
+// the GCM key is never reused for two chunks. Reading from r rather than a
+// pre-loaded []byte means a large input is never fully buffered before
+// encryption can start; a 1-byte Peek after each full read is how the last
+// chunk is detected without knowing the total length up front. The last
+// chunk's associated data is tagged to prevent an attacker from truncating
+// the stream and passing off a prefix as the whole file.
+func (app *App) encryptChunks(w io.Writer, r io.Reader, gcm cipher.AEAD, baseNonce []byte) error {
+	br := bufio.NewReaderSize(r, streamChunkSize)
+	buf := make([]byte, streamChunkSize)
+
+	for i := uint64(0); ; i++ {
+		n, err := io.ReadFull(br, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return fmt.Errorf("reading plaintext: %w", err)
+		}
+		last := err == io.ErrUnexpectedEOF || err == io.EOF
+		if !last {
+			if _, peekErr := br.Peek(1); peekErr == io.EOF {
+				last = true
+			} else if peekErr != nil {
+				return fmt.Errorf("reading plaintext: %w", peekErr)
+			}
+		}
+
+		sealed := gcm.Seal(nil, chunkNonce(baseNonce, i), buf[:n], chunkAAD(i, last))
+
+		var lenBuf [4]byte
+		binary.BigEndian.PutUint32(lenBuf[:], uint32(len(sealed)))
+		if _, err := w.Write(lenBuf[:]); err != nil {
+			return err
+		}
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+
+		if last {
+			return nil
+		}
+	}
+}
+
+// chunkNonce derives a unique per-chunk nonce by XORing the chunk counter
+// into the low 8 bytes of the base nonce.
+func chunkNonce(baseNonce []byte, counter uint64) []byte {
+	nonce := make([]byte, len(baseNonce))
+	copy(nonce, baseNonce)
+	offset := len(nonce) - 8
+	var counterBuf [8]byte
+	binary.BigEndian.PutUint64(counterBuf[:], counter)
+	for i, b := range counterBuf {
+		nonce[offset+i] ^= b
+	}
+	return nonce
+}
+
+// chunkAAD mixes the chunk index and a "last chunk" flag into the AEAD
+// associated data, so a truncated or reordered stream fails to decrypt.
+func chunkAAD(counter uint64, last bool) []byte {
+	aad := make([]byte, 9)
+	binary.BigEndian.PutUint64(aad[:8], counter)
+	if last {
+		aad[8] = 1
+	}
+	return aad
+}
+
+// readSource resolves filename to its raw bytes. URI-style sources such as
+// keyring://, op://, vault:// and exec:// are dispatched to the matching
+// SecretSource; anything else is read as a plain path on disk.
+func (app *App) readSource(filename string) ([]byte, error) {
+	if source, ok := secretSource(filename); ok {
+		data, err := source.Read(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("reading secret source %s: %w", filename, err)
+		}
+		return data, nil
+	}
+
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("opening file for reading: %w", err)
 	}
+	return data, nil
+}
 
-	// Handle encrypted environment
-	if len(data) >= len(magic) && string(data[:len(magic)]) == magic {
-		// discard magic header
-		data = data[len(magic):]
+// readFile reads and parses filename into env assignments. Unlike
+// decryptFile, it never holds the whole plaintext of a magicV3 stream file
+// in memory at once: chunks are decrypted and scanned for variables one at
+// a time, directly through parseEnvReader, so a large secrets file doesn't
+// need roughly twice its size in RAM to load. Every other format (age,
+// single-shot magicV2, legacy) is inherently sealed as one indivisible
+// unit, so it still goes through the full-buffer decryptData path.
+func (app *App) readFile(filename string, identities []string, fix bool) ([]string, error) {
+	data, err := app.readSource(filename)
+	if err != nil {
+		return nil, err
+	}
 
+	if isReedSolomonFile(data) {
+		data, err = app.readReedSolomon(data, fix)
+		if err != nil {
+			return nil, fmt.Errorf("reading erasure-coded file: %w", err)
+		}
+	}
+
+	if len(data) >= len(magicV3) && string(data[:len(magicV3)]) == magicV3 {
 		password, err := app.readPassword("Password for %s: ", filename)
 		if err != nil {
 			return nil, fmt.Errorf("reading password: %w", err)
 		}
 
-		data, err = app.decrypt(data, password)
+		r, err := app.openStream(data[len(magicV3):], password)
 		if err != nil {
 			return nil, fmt.Errorf("decrypting environment: %w", err)
 		}
+
+		env, err := app.parseEnvReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("parsing environment: %w", err)
+		}
+		return env, nil
+	}
+
+	data, _, err = app.decryptData(data, filename, identities)
+	if err != nil {
+		return nil, err
 	}
 
 	env, err := app.parseEnv(data)
@@ -201,13 +445,99 @@ func (app *App) readFile(filename string) ([]string, error) {
 	return env, nil
 }
 
+// decryptFile reads filename and, if it's encrypted, decrypts it, unwrapping
+// Reed-Solomon erasure coding first if present. It returns the decrypted
+// (or plain) bytes along with the password used, if any, so callers that
+// need to re-encrypt the same file (such as `envs edit`) don't have to
+// prompt for it twice.
+func (app *App) decryptFile(filename string, identities []string, fix bool) ([]byte, []byte, error) {
+	data, err := app.readSource(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Unwrap Reed-Solomon erasure coding, if present, before looking at
+	// the encryption format underneath
+	if isReedSolomonFile(data) {
+		data, err = app.readReedSolomon(data, fix)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading erasure-coded file: %w", err)
+		}
+	}
+
+	return app.decryptData(data, filename, identities)
+}
+
+// decryptData dispatches already-resolved bytes (post Reed-Solomon, if any)
+// to the decryptor matching their format, returning the decrypted (or
+// plain) bytes along with the password used, if any.
+func (app *App) decryptData(data []byte, filename string, identities []string) ([]byte, []byte, error) {
+	var password []byte
+	var err error
+
+	// Handle encrypted environment
+	switch {
+	case isAgeFile(data):
+		data, err = app.decryptAge(data, filename, identities)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decrypting environment: %w", err)
+		}
+
+	case len(data) >= len(magicV3) && string(data[:len(magicV3)]) == magicV3:
+		data = data[len(magicV3):]
+
+		password, err = app.readPassword("Password for %s: ", filename)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading password: %w", err)
+		}
+
+		data, err = app.decryptStream(data, password)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decrypting environment: %w", err)
+		}
+
+	case len(data) >= len(magicV2) && string(data[:len(magicV2)]) == magicV2:
+		data = data[len(magicV2):]
+
+		password, err = app.readPassword("Password for %s: ", filename)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading password: %w", err)
+		}
+
+		data, err = app.decryptSingleShot(data, password)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decrypting environment: %w", err)
+		}
+
+	case len(data) >= len(magic) && string(data[:len(magic)]) == magic:
+		// discard magic header
+		data = data[len(magic):]
+
+		password, err = app.readPassword("Password for %s: ", filename)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading password: %w", err)
+		}
+
+		data, err = app.decryptLegacy(data, password)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decrypting environment: %w", err)
+		}
+	}
+
+	return data, password, nil
+}
+
+// parseEnv parses data into KEY=VALUE assignments.
 func (app *App) parseEnv(data []byte) ([]string, error) {
+	return app.parseEnvReader(bytes.NewReader(data))
+}
+
+// parseEnvReader parses r into KEY=VALUE assignments line by line, without
+// requiring the whole input to be held in memory at once.
+func (app *App) parseEnvReader(r io.Reader) ([]string, error) {
 	var env []string
-	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return nil, fmt.Errorf("parsing environment: %w", err)
-		}
 		line := scanner.Text()
 
 		// Skip empty lines and comments
@@ -222,11 +552,28 @@ func (app *App) parseEnv(data []byte) ([]string, error) {
 
 		env = append(env, fmt.Sprintf("%s=%s", elems[0], elems[1]))
 	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("parsing environment: %w", err)
+	}
 	return env, nil
 }
 
-func (app *App) decrypt(data, password []byte) ([]byte, error) {
-	gcm, err := app.createGCM(password)
+// decryptSingleShot decrypts a magicV2 file: a KDF header followed by a
+// single nonce and one AEAD-sealed ciphertext, as written by chunk0-1's
+// encryptStdin. magicV3 files are never routed here, so this never has to
+// guess whether a streaming mode byte follows the KDF header.
+func (app *App) decryptSingleShot(data, password []byte) ([]byte, error) {
+	header, data, err := app.readKDFHeader(data)
+	if err != nil {
+		return nil, fmt.Errorf("reading kdf header: %w", err)
+	}
+
+	key, err := app.deriveKey(password, header)
+	if err != nil {
+		return nil, fmt.Errorf("deriving key: %w", err)
+	}
+
+	gcm, err := app.newGCM(key)
 	if err != nil {
 		return nil, fmt.Errorf("initializing encryption algorithm: %w", err)
 	}
@@ -241,21 +588,141 @@ func (app *App) decrypt(data, password []byte) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("decrypting: %w", err)
 	}
-
 	return data, nil
 }
 
-func (app *App) createGCM(password []byte) (cipher.AEAD, error) {
-	// Hash the password with a secure algorithm
+// decryptStream decrypts a magicV3 file in full: a KDF header followed by
+// the chunked stream written by encryptChunks. Callers that can work from a
+// stream instead of a fully materialized []byte, such as readFile, should
+// use openStream directly instead.
+func (app *App) decryptStream(data, password []byte) ([]byte, error) {
+	r, err := app.openStream(data, password)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// openStream reads the KDF header of a magicV3 body, derives the key, and
+// returns a reader that lazily decrypts the chunk stream written by
+// encryptChunks one chunk at a time, so a large secrets file's plaintext
+// never needs to be held in memory all at once just to be scanned for
+// variables.
+func (app *App) openStream(data, password []byte) (io.Reader, error) {
+	header, data, err := app.readKDFHeader(data)
+	if err != nil {
+		return nil, fmt.Errorf("reading kdf header: %w", err)
+	}
+
+	key, err := app.deriveKey(password, header)
+	if err != nil {
+		return nil, fmt.Errorf("deriving key: %w", err)
+	}
+
+	gcm, err := app.newGCM(key)
+	if err != nil {
+		return nil, fmt.Errorf("initializing encryption algorithm: %w", err)
+	}
+
+	if len(data) < 4 {
+		return nil, errors.New("stream header truncated")
+	}
+	_ = binary.BigEndian.Uint32(data[:4]) // chunk size, informational only
+	data = data[4:]
+
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("stream header truncated")
+	}
+	baseNonce, data := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+
+	return &chunkReader{data: data, gcm: gcm, baseNonce: baseNonce}, nil
+}
+
+// chunkReader reverses encryptChunks: it decrypts one length-prefixed,
+// AEAD-sealed chunk at a time as Read is called, refusing to yield any
+// plaintext from a chunk unless the stream has genuinely ended on a chunk
+// whose associated data was tagged "last".
+type chunkReader struct {
+	data      []byte
+	gcm       cipher.AEAD
+	baseNonce []byte
+	counter   uint64
+	buf       []byte
+	finished  bool
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 && !r.finished {
+		if len(r.data) < 4 {
+			return 0, errors.New("truncated chunk stream")
+		}
+		chunkLen := binary.BigEndian.Uint32(r.data[:4])
+		rest := r.data[4:]
+		if uint64(len(rest)) < uint64(chunkLen) {
+			return 0, errors.New("truncated chunk stream")
+		}
+		sealed, tail := rest[:chunkLen], rest[chunkLen:]
+
+		// The AAD must agree with whether this is really the final chunk on
+		// disk; a truncated file makes an earlier chunk look "last" to the
+		// reader, but its AAD was sealed with last=false, so authentication
+		// fails instead of silently returning a partial file.
+		last := len(tail) == 0
+		chunk, err := r.gcm.Open(nil, chunkNonce(r.baseNonce, r.counter), sealed, chunkAAD(r.counter, last))
+		if err != nil {
+			return 0, fmt.Errorf("decrypting chunk %d (truncated stream?): %w", r.counter, err)
+		}
+
+		r.buf, r.data, r.finished = chunk, tail, last
+		r.counter++
+	}
+
+	if len(r.buf) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// decryptLegacy decrypts files written with the original, unsalted
+// SHA-256-derived key, for backwards compatibility with files written
+// before the KDF header was introduced.
+func (app *App) decryptLegacy(data, password []byte) ([]byte, error) {
 	passwordHash := sha256.Sum256(password)
 
-	// Initialize the AES block cipher
 	block, err := aes.NewCipher(passwordHash[:])
 	if err != nil {
 		return nil, fmt.Errorf("initializing block cipher: %w", err)
 	}
 
-	// Initialize the gcm algorithm
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("initializing gcm: %w", err)
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("data does not contain nonce")
+	}
+	var nonce []byte
+	nonce, data = data[:gcm.NonceSize()], data[gcm.NonceSize():]
+
+	data, err = gcm.Open(data[:0], nonce, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting: %w", err)
+	}
+
+	return data, nil
+}
+
+// newGCM wraps an already-derived 32-byte key in AES-256-GCM.
+func (app *App) newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("initializing block cipher: %w", err)
+	}
+
 	gcm, err := cipher.NewGCM(block)
 	if err != nil {
 		return nil, fmt.Errorf("initializing gcm: %w", err)
@@ -264,6 +731,93 @@ func (app *App) createGCM(password []byte) (cipher.AEAD, error) {
 	return gcm, nil
 }
 
+// deriveKey turns a password into an AES-256 key using the KDF identified
+// in header.
+func (app *App) deriveKey(password []byte, header kdfHeader) ([]byte, error) {
+	switch header.id {
+	case kdfScrypt:
+		return scrypt.Key(password, header.salt, int(header.n), int(header.r), int(header.p), keyLen)
+	case kdfArgon2id:
+		return argon2.IDKey(password, header.salt, header.time, header.memory, header.threads, keyLen), nil
+	default:
+		return nil, fmt.Errorf("unsupported kdf id %d", header.id)
+	}
+}
+
+// writeKDFHeader serializes header as: version, kdf id, kdf params, salt
+// length, salt.
+func (app *App) writeKDFHeader(w io.Writer, header kdfHeader) error {
+	buf := []byte{headerVersion, header.id}
+
+	switch header.id {
+	case kdfScrypt:
+		buf = binary.BigEndian.AppendUint32(buf, header.n)
+		buf = binary.BigEndian.AppendUint32(buf, header.r)
+		buf = binary.BigEndian.AppendUint32(buf, header.p)
+	case kdfArgon2id:
+		buf = binary.BigEndian.AppendUint32(buf, header.time)
+		buf = binary.BigEndian.AppendUint32(buf, header.memory)
+		buf = append(buf, header.threads)
+	default:
+		return fmt.Errorf("unsupported kdf id %d", header.id)
+	}
+
+	buf = append(buf, byte(len(header.salt)))
+	buf = append(buf, header.salt...)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// readKDFHeader parses the header written by writeKDFHeader off the front
+// of data, returning the header and the remaining (nonce + ciphertext) bytes.
+func (app *App) readKDFHeader(data []byte) (kdfHeader, []byte, error) {
+	if len(data) < 2 {
+		return kdfHeader{}, nil, errors.New("header truncated")
+	}
+	version, id := data[0], data[1]
+	data = data[2:]
+
+	if version != headerVersion {
+		return kdfHeader{}, nil, fmt.Errorf("unsupported header version %d", version)
+	}
+
+	header := kdfHeader{id: id}
+
+	switch id {
+	case kdfScrypt:
+		if len(data) < 12 {
+			return kdfHeader{}, nil, errors.New("header truncated")
+		}
+		header.n = binary.BigEndian.Uint32(data[0:4])
+		header.r = binary.BigEndian.Uint32(data[4:8])
+		header.p = binary.BigEndian.Uint32(data[8:12])
+		data = data[12:]
+	case kdfArgon2id:
+		if len(data) < 9 {
+			return kdfHeader{}, nil, errors.New("header truncated")
+		}
+		header.time = binary.BigEndian.Uint32(data[0:4])
+		header.memory = binary.BigEndian.Uint32(data[4:8])
+		header.threads = data[8]
+		data = data[9:]
+	default:
+		return kdfHeader{}, nil, fmt.Errorf("unsupported kdf id %d", id)
+	}
+
+	if len(data) < 1 {
+		return kdfHeader{}, nil, errors.New("header truncated")
+	}
+	saltLen := int(data[0])
+	data = data[1:]
+	if len(data) < saltLen {
+		return kdfHeader{}, nil, errors.New("header truncated")
+	}
+	header.salt, data = data[:saltLen], data[saltLen:]
+
+	return header, data, nil
+}
+
 func (app *App) exec(name string, args []string, env []string) error {
 	// Prepare the given command with I/O and environment
 	cmd := exec.Command(name, args...)
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+)
+
+// keyringService is the service name envs stores and retrieves secrets
+// under in the OS keyring.
+const keyringService = "envs"
+
+// SecretSource resolves a -f URI to its dotenv-formatted contents, which
+// then flow through parseEnv like any other file.
+type SecretSource interface {
+	Read(ctx context.Context) ([]byte, error)
+}
+
+// secretSource parses filename as a URI and returns the SecretSource that
+// handles its scheme, or ok=false if filename should be treated as a plain
+// path on disk.
+func secretSource(filename string) (source SecretSource, ok bool) {
+	switch {
+	case strings.HasPrefix(filename, "keyring://"):
+		return keyringSource{profile: strings.TrimPrefix(filename, "keyring://")}, true
+	case strings.HasPrefix(filename, "op://"):
+		return opSource{item: strings.TrimPrefix(filename, "op://")}, true
+	case strings.HasPrefix(filename, "vault://"):
+		return vaultSource{path: strings.TrimPrefix(filename, "vault://")}, true
+	case strings.HasPrefix(filename, "exec://"):
+		return execSource{command: strings.TrimPrefix(filename, "exec://")}, true
+	default:
+		return nil, false
+	}
+}
+
+// keyringSource reads a secret from the OS keyring (macOS Keychain, Windows
+// Credential Manager, Secret Service on Linux, ...).
+type keyringSource struct {
+	profile string
+}
+
+func (s keyringSource) Read(ctx context.Context) ([]byte, error) {
+	secret, err := keyring.Get(keyringService, s.profile)
+	if err != nil {
+		return nil, fmt.Errorf("reading keyring profile %s: %w", s.profile, err)
+	}
+	return []byte(secret), nil
+}
+
+// opSource reads a 1Password item via the `op` CLI.
+type opSource struct {
+	item string
+}
+
+func (s opSource) Read(ctx context.Context) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "op", "read", "op://"+s.item)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("running op read: %w", err)
+	}
+	return out, nil
+}
+
+// vaultSource reads a HashiCorp Vault KV v2 secret over the HTTP API,
+// authenticating with VAULT_TOKEN or ~/.vault-token, the same way the
+// vault CLI does.
+type vaultSource struct {
+	path string
+}
+
+func (s vaultSource) Read(ctx context.Context) ([]byte, error) {
+	token, err := vaultToken()
+	if err != nil {
+		return nil, err
+	}
+
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		addr = "https://127.0.0.1:8200"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(addr, "/")+"/v1/"+s.path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("building vault request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("querying vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned %s", resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding vault response: %w", err)
+	}
+
+	var buf bytes.Buffer
+	for key, value := range body.Data.Data {
+		fmt.Fprintf(&buf, "%s=%s\n", key, value)
+	}
+	return buf.Bytes(), nil
+}
+
+func vaultToken() (string, error) {
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("locating home directory: %w", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(home, ".vault-token"))
+	if err != nil {
+		return "", fmt.Errorf("reading ~/.vault-token: %w", err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// execSource runs a helper command and captures its stdout, like a
+// git-credential helper.
+type execSource struct {
+	command string
+}
+
+func (s execSource) Read(ctx context.Context) ([]byte, error) {
+	fields := strings.Fields(s.command)
+	if len(fields) == 0 {
+		return nil, errors.New("empty exec:// command")
+	}
+
+	cmd := exec.CommandContext(ctx, fields[0], fields[1:]...)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("running %s: %w", fields[0], err)
+	}
+	return out, nil
+}